@@ -1,13 +1,25 @@
 package shell
 
 import (
+	"bytes"
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"github.com/gogo/protobuf/proto"
 	"github.com/tron-us/go-common/v2/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	utils "github.com/TRON-US/go-btfs-api/utils"
@@ -42,6 +54,454 @@ type Storage struct {
 	Shards   map[string]Shard
 }
 
+// Signer abstracts over where the private key used to sign storage
+// contracts and payment messages lives, so SignContracts, SignData,
+// SignBalanceData and the pay channel/request signing methods never need
+// to read a raw hex private key out of utils.PrivateKey directly.
+type Signer interface {
+	Sign(ctx context.Context, msg proto.Message) ([]byte, error)
+	SignRaw(ctx context.Context, data []byte) ([]byte, error)
+	PublicKey() ic.PubKey
+}
+
+// memorySigner signs with a plain, unencrypted in-memory private key. This
+// is what SignContracts/SignData/SignBalanceData did before Signer existed.
+type memorySigner struct {
+	privKey ic.PrivKey
+}
+
+// NewMemorySigner returns a Signer that signs with privateKey (hex-encoded,
+// as read from config or an env var) held in memory for the process
+// lifetime.
+func NewMemorySigner(privateKey string) (Signer, error) {
+	privKey, err := crypto.ToPrivKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &memorySigner{privKey: privKey}, nil
+}
+
+func (m *memorySigner) Sign(ctx context.Context, msg proto.Message) ([]byte, error) {
+	return crypto.Sign(m.privKey, msg)
+}
+
+func (m *memorySigner) SignRaw(ctx context.Context, data []byte) ([]byte, error) {
+	return m.privKey.Sign(data)
+}
+
+func (m *memorySigner) PublicKey() ic.PubKey {
+	return m.privKey.GetPublic()
+}
+
+// keystoreSigner is a Signer backed by a passphrase-encrypted private key
+// file on disk, in the style of go-ethereum's accounts keystore: the key is
+// decrypted into memory only for the duration of a single Sign/SignRaw
+// call.
+type keystoreSigner struct {
+	path       string
+	passphrase string
+}
+
+// NewKeystoreSigner returns a Signer that decrypts the private key stored
+// at path with passphrase on every signing call, instead of holding it
+// unencrypted in memory for the life of the process.
+func NewKeystoreSigner(path, passphrase string) Signer {
+	return &keystoreSigner{path: path, passphrase: passphrase}
+}
+
+func (k *keystoreSigner) unlock() (ic.PrivKey, error) {
+	ciphertext, err := ioutil.ReadFile(k.path)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := decryptKeystoreFile(ciphertext, k.passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.ToPrivKey(privateKey)
+}
+
+func (k *keystoreSigner) Sign(ctx context.Context, msg proto.Message) ([]byte, error) {
+	privKey, err := k.unlock()
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Sign(privKey, msg)
+}
+
+func (k *keystoreSigner) SignRaw(ctx context.Context, data []byte) ([]byte, error) {
+	privKey, err := k.unlock()
+	if err != nil {
+		return nil, err
+	}
+	return privKey.Sign(data)
+}
+
+func (k *keystoreSigner) PublicKey() ic.PubKey {
+	privKey, err := k.unlock()
+	if err != nil {
+		return nil
+	}
+	return privKey.GetPublic()
+}
+
+// EncryptKeystoreFile encrypts privateKey with passphrase and writes it to
+// path, for later use with NewKeystoreSigner.
+func EncryptKeystoreFile(path, privateKey, passphrase string) error {
+	ciphertext, err := encryptKeystoreFile([]byte(privateKey), passphrase)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, ciphertext, 0600)
+}
+
+func encryptKeystoreFile(privateKey []byte, passphrase string) ([]byte, error) {
+	gcm, err := keystoreGCM(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, privateKey, nil), nil
+}
+
+func decryptKeystoreFile(ciphertext []byte, passphrase string) (string, error) {
+	gcm, err := keystoreGCM(passphrase)
+	if err != nil {
+		return "", err
+	}
+	nonceSize := gcm.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return "", errors.New("keystore file is corrupt or truncated")
+	}
+	nonce, sealed := ciphertext[:nonceSize], ciphertext[nonceSize:]
+	privateKey, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(privateKey), nil
+}
+
+func keystoreGCM(passphrase string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(passphrase))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// remoteSigner delegates signing to a remote service over HTTP (e.g. one
+// fronting an HSM or KMS), so the private key never enters this process.
+type remoteSigner struct {
+	endpoint   string
+	httpClient *http.Client
+	pubKey     ic.PubKey
+}
+
+// NewRemoteSigner returns a Signer that POSTs the bytes to sign to endpoint
+// and returns the response body as the signature. pubKey is reported by
+// PublicKey and is expected to match the key the remote service signs
+// with.
+func NewRemoteSigner(endpoint string, pubKey ic.PubKey) Signer {
+	return &remoteSigner{endpoint: endpoint, httpClient: http.DefaultClient, pubKey: pubKey}
+}
+
+func (r *remoteSigner) Sign(ctx context.Context, msg proto.Message) ([]byte, error) {
+	raw, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return r.SignRaw(ctx, raw)
+}
+
+func (r *remoteSigner) SignRaw(ctx context.Context, data []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("remote signer %s returned status %d", r.endpoint, resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (r *remoteSigner) PublicKey() ic.PubKey {
+	return r.pubKey
+}
+
+// signersMu guards signers, which holds the Signer attached to each Shell
+// via WithSigner. Shell can't gain a field for this directly (it's
+// defined outside this file), so it's tracked out-of-line, keyed by
+// instance, instead of as a single package-wide default that every Shell
+// would otherwise share.
+var (
+	signersMu sync.RWMutex
+	signers   = map[*Shell]Signer{}
+)
+
+// WithSigner attaches signer to s, so SignContracts, SignData,
+// SignBalanceData, StorageUploadSignPayChannel and
+// StorageUploadSignPayRequest sign through it instead of reading a raw hex
+// private key out of utils.PrivateKey. Useful for long-running clients
+// that keep key material in a hardware wallet or KMS rather than process
+// memory. The signer is scoped to s, so other Shell instances are
+// unaffected.
+func (s *Shell) WithSigner(signer Signer) *Shell {
+	signersMu.Lock()
+	signers[s] = signer
+	signersMu.Unlock()
+	return s
+}
+
+// signer returns the Signer attached to s via WithSigner, falling back to
+// an in-memory signer over utils.PrivateKey for backward compatibility.
+func (s *Shell) signer() (Signer, error) {
+	signersMu.RLock()
+	signer, ok := signers[s]
+	signersMu.RUnlock()
+	if ok {
+		return signer, nil
+	}
+	if utils.PrivateKey == "" {
+		return nil, errors.New("private key not available in configuration file or environment variable")
+	}
+	return NewMemorySigner(utils.PrivateKey)
+}
+
+// Close releases the per-Shell state WithSigner, SetSessionStore,
+// LegacySessionSig and ListStorageAsks/GetStorageAsk attach to s out of
+// line (signers, sessionStores, legacySessionSigs, askCaches). Shell
+// can't hold this state in a field directly since it's defined outside
+// this file, so it lives in package-level maps keyed by *s; without
+// Close, every Shell that ever called one of those methods would stay
+// reachable (and unreclaimed) for the life of the process. Call it when
+// s is no longer needed.
+func (s *Shell) Close() {
+	signersMu.Lock()
+	delete(signers, s)
+	signersMu.Unlock()
+
+	sessionStoresMu.Lock()
+	delete(sessionStores, s)
+	sessionStoresMu.Unlock()
+
+	legacySessionSigsMu.Lock()
+	delete(legacySessionSigs, s)
+	legacySessionSigsMu.Unlock()
+
+	askCachesMu.Lock()
+	delete(askCaches, s)
+	askCachesMu.Unlock()
+}
+
+// UploadProgress reports per-shard signing progress for a resumable
+// upload session, derived from StorageUploadStatus.
+type UploadProgress struct {
+	Sid    string
+	Status string
+	Total  int
+	Signed int
+	Shards map[string]Shard
+}
+
+// SessionRecord is the persisted state of a resumable upload session:
+// enough to re-drive StorageUploadGetContractBatch/StorageUploadSignBatch
+// without re-signing shards that were already committed.
+type SessionRecord struct {
+	Sid          string
+	Hash         string
+	Uts          string
+	State        SessionState
+	SignedShards map[string]bool
+}
+
+// SessionStore persists SessionRecord so a killed client can resume an
+// upload mid-flight via StorageUploadResume.
+type SessionStore interface {
+	Save(record SessionRecord) error
+	Load(sid string) (SessionRecord, error)
+	Delete(sid string) error
+}
+
+// fileSessionStore is the default SessionStore: one JSON file per session
+// in a directory.
+type fileSessionStore struct {
+	dir string
+}
+
+// NewFileSessionStore returns a SessionStore that persists session state as
+// one JSON file per session under dir.
+func NewFileSessionStore(dir string) SessionStore {
+	return &fileSessionStore{dir: dir}
+}
+
+func (f *fileSessionStore) path(sid string) string {
+	return filepath.Join(f.dir, sid+".json")
+}
+
+func (f *fileSessionStore) Save(record SessionRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(f.path(record.Sid), b, 0600)
+}
+
+func (f *fileSessionStore) Load(sid string) (SessionRecord, error) {
+	var record SessionRecord
+	b, err := ioutil.ReadFile(f.path(sid))
+	if err != nil {
+		return record, err
+	}
+	err = json.Unmarshal(b, &record)
+	return record, err
+}
+
+func (f *fileSessionStore) Delete(sid string) error {
+	err := os.Remove(f.path(sid))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// defaultSessionStore is the fallback SessionStore for any Shell that
+// hasn't called SetSessionStore. It's stateless (one file per session ID
+// under the OS temp dir) and safe to share, unlike signers or the
+// legacy-sig flag, which are genuinely per-Shell configuration.
+var defaultSessionStore SessionStore = NewFileSessionStore(os.TempDir())
+
+// sessionStoresMu guards sessionStores, which holds the SessionStore
+// attached to each Shell via SetSessionStore. Shell can't gain a field
+// for this directly (it's defined outside this file), so it's tracked
+// out-of-line, keyed by instance, instead of as a single package-wide
+// default that every Shell would otherwise share.
+var (
+	sessionStoresMu sync.RWMutex
+	sessionStores   = map[*Shell]SessionStore{}
+)
+
+// SetSessionStore overrides the SessionStore s's StorageUpload,
+// StorageUploadResume and StorageUploadProgress use to track resumable
+// upload session state (e.g. one backed by a database). Other Shell
+// instances are unaffected.
+func (s *Shell) SetSessionStore(store SessionStore) {
+	sessionStoresMu.Lock()
+	sessionStores[s] = store
+	sessionStoresMu.Unlock()
+}
+
+func (s *Shell) sessionStore() SessionStore {
+	sessionStoresMu.RLock()
+	store, ok := sessionStores[s]
+	sessionStoresMu.RUnlock()
+	if ok {
+		return store
+	}
+	return defaultSessionStore
+}
+
+// PipelineOpts configure the local, client-side worker pool that
+// StorageUploadResume uses to sign shards concurrently. They are distinct
+// from StorageUploadOpts, which configure the outgoing HTTP request.
+type PipelineOpts = func(*pipelineConfig) error
+
+type pipelineConfig struct {
+	concurrency int
+	maxRetries  int
+	backoff     time.Duration
+}
+
+// Concurrency sets how many shards StorageUploadResume may sign in
+// parallel. Defaults to 1 (sequential) when not given.
+func Concurrency(n int) PipelineOpts {
+	return func(c *pipelineConfig) error {
+		c.concurrency = n
+		return nil
+	}
+}
+
+// RetryPolicy sets how many times StorageUploadResume retries a shard whose
+// signing attempt failed, and how long it waits between attempts.
+func RetryPolicy(maxRetries int, backoff time.Duration) PipelineOpts {
+	return func(c *pipelineConfig) error {
+		c.maxRetries = maxRetries
+		c.backoff = backoff
+		return nil
+	}
+}
+
+// runSigningPipeline signs unsigned contracts concurrently across
+// cfg.concurrency workers, retrying each shard up to cfg.maxRetries times.
+// Already-signed shards (per record.SignedShards) are skipped, and record
+// is persisted to store after every shard that gets signed so a killed
+// client can resume without redoing that work.
+func runSigningPipeline(ctx context.Context, store SessionStore, record SessionRecord, contracts Contracts, signer Signer, cfg *pipelineConfig) (*Contracts, error) {
+	concurrency := cfg.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	jobs := make(chan int, len(contracts.Contracts))
+	results := make(chan error, len(contracts.Contracts))
+	var mu sync.Mutex
+
+	worker := func() {
+		for idx := range jobs {
+			item := contracts.Contracts[idx]
+			mu.Lock()
+			alreadySigned := record.SignedShards[item.Key]
+			mu.Unlock()
+			if alreadySigned {
+				results <- nil
+				continue
+			}
+
+			var err error
+			for attempt := 0; attempt <= cfg.maxRetries; attempt++ {
+				single := Contracts{Contracts: []ContractItem{item}}
+				var signed *Contracts
+				signed, err = single.SignContracts(ctx, signer, record.State)
+				if err == nil {
+					mu.Lock()
+					contracts.Contracts[idx] = signed.Contracts[0]
+					record.SignedShards[item.Key] = true
+					_ = store.Save(record)
+					mu.Unlock()
+					break
+				}
+				if attempt < cfg.maxRetries {
+					time.Sleep(cfg.backoff)
+				}
+			}
+			results <- err
+		}
+	}
+
+	for i := 0; i < concurrency; i++ {
+		go worker()
+	}
+	for idx := range contracts.Contracts {
+		jobs <- idx
+	}
+	close(jobs)
+
+	for range contracts.Contracts {
+		if err := <-results; err != nil {
+			return nil, err
+		}
+	}
+	return &contracts, nil
+}
+
 type ContractItem struct {
 	Key      string `json:"key"`
 	Contract string `json:"contract"`
@@ -73,26 +533,19 @@ func Hosts(hosts string) StorageOpts {
 	}
 }
 
-
-func (d UnsignedData) SignData(privateKey string) ([]byte, error) {
-	privKey, _ := crypto.ToPrivKey(privateKey)
-	signedData, err := privKey.Sign([]byte(d.Unsigned))
-	if err != nil {
-		return nil, err
-	}
-	return signedData, nil
+func (d UnsignedData) SignData(ctx context.Context, signer Signer) ([]byte, error) {
+	return signer.SignRaw(ctx, []byte(d.Unsigned))
 }
 
-func (d UnsignedData) SignBalanceData(privateKey string) (*ledgerpb.SignedPublicKey, error) {
-	privKey, _ := crypto.ToPrivKey(privateKey)
-	pubKeyRaw, err := privKey.GetPublic().Raw()
+func (d UnsignedData) SignBalanceData(ctx context.Context, signer Signer) (*ledgerpb.SignedPublicKey, error) {
+	pubKeyRaw, err := signer.PublicKey().Raw()
 	if err != nil {
 		return &ledgerpb.SignedPublicKey{}, err
 	}
 	lgPubKey := &ledgerpb.PublicKey{
 		Key: pubKeyRaw,
 	}
-	sig, err := crypto.Sign(privKey, lgPubKey)
+	sig, err := signer.Sign(ctx, lgPubKey)
 	if err != nil {
 		return &ledgerpb.SignedPublicKey{}, err
 	}
@@ -132,38 +585,148 @@ func bytesToString(data []byte, encoding int) (string, error) {
 		return "", fmt.Errorf(`unexpected parameter [%d] is given, either "text" or "base64" should be used`, encoding)
 	}
 }
-func (c Contracts) SignContracts(privateKey string, sessionStatus string) (*Contracts, error) {
-	// Perform signing using private key
-	privKey, err := crypto.ToPrivKey(privateKey)
-	if err != nil {
-		log.Error("%s", zap.Error(err))
+
+// SessionState enumerates the stages of the offline, multi-step storage
+// signing pipeline, replacing the ad hoc sessionStatus strings
+// ("initSignReadyEscrow", ...) that used to thread through every
+// StorageUpload* signing call as plain strings.
+type SessionState int
+
+const (
+	SessionStateUnknown SessionState = iota
+	SessionStateEscrow
+	SessionStateGuard
+	SessionStateBalance
+	SessionStatePayChannel
+	SessionStatePayRequest
+	SessionStateDone
+	// SessionStateRepair is used by the repair contract signing flow
+	// (StorageRepairGetContractBatch, StorageRepairSignBatch) rather than
+	// StorageSession's upload pipeline.
+	SessionStateRepair
+)
+
+// String returns the wire value a SessionState is sent to the node as,
+// matching the historical sessionStatus strings.
+func (st SessionState) String() string {
+	switch st {
+	case SessionStateEscrow:
+		return "initSignReadyEscrow"
+	case SessionStateGuard:
+		return "initSignReadyGuard"
+	case SessionStateBalance:
+		return "initSignReadyBalance"
+	case SessionStatePayChannel:
+		return "initSignReadyPayChannel"
+	case SessionStatePayRequest:
+		return "initSignReadyPayRequest"
+	case SessionStateDone:
+		return "initSignReadyDone"
+	case SessionStateRepair:
+		return "initSignReadyRepair"
+	default:
+		return "unknown"
+	}
+}
+
+// Action identifies the signing call a StorageSession wants the caller to
+// make next, as returned by StorageSession.Next.
+type Action int
+
+const (
+	ActionNone Action = iota
+	ActionSignEscrowContracts
+	ActionSignGuardContracts
+	ActionSignBalance
+	ActionSignPayChannel
+	ActionSignPayRequest
+	ActionDone
+)
+
+// sessionTransitions is the only valid path through the offline-signing
+// pipeline: escrow contracts, then guard contracts, then balance, then pay
+// channel, then pay request, then done.
+var sessionTransitions = map[SessionState]SessionState{
+	SessionStateUnknown:    SessionStateEscrow,
+	SessionStateEscrow:     SessionStateGuard,
+	SessionStateGuard:      SessionStateBalance,
+	SessionStateBalance:    SessionStatePayChannel,
+	SessionStatePayChannel: SessionStatePayRequest,
+	SessionStatePayRequest: SessionStateDone,
+}
+
+var sessionStateActions = map[SessionState]Action{
+	SessionStateEscrow:     ActionSignEscrowContracts,
+	SessionStateGuard:      ActionSignGuardContracts,
+	SessionStateBalance:    ActionSignBalance,
+	SessionStatePayChannel: ActionSignPayChannel,
+	SessionStatePayRequest: ActionSignPayRequest,
+	SessionStateDone:       ActionDone,
+}
+
+// StorageSession drives the offline, multi-step storage signing pipeline
+// for one upload: callers advance it with Next until it returns ActionDone,
+// instead of manually ordering the StorageUploadGetContractBatch/
+// StorageUploadSign* RPCs themselves.
+type StorageSession struct {
+	Sid   string
+	Hash  string
+	Uts   string
+	State SessionState
+}
+
+// NewStorageSession starts a StorageSession for sid/hash/uts at the
+// beginning of the offline-signing pipeline.
+func NewStorageSession(sid, hash, uts string) *StorageSession {
+	return &StorageSession{Sid: sid, Hash: hash, Uts: uts, State: SessionStateUnknown}
+}
+
+// Next advances the session to the next state in the signing pipeline and
+// returns the Action the caller must now perform. It fails loudly if
+// called again once the pipeline is already done, or from a state with no
+// defined transition.
+func (sess *StorageSession) Next(ctx context.Context) (Action, error) {
+	next, ok := sessionTransitions[sess.State]
+	if !ok {
+		return ActionNone, fmt.Errorf("storage session %s: invalid transition from state %q", sess.Sid, sess.State)
+	}
+	action, ok := sessionStateActions[next]
+	if !ok {
+		return ActionNone, fmt.Errorf("storage session %s: no action defined for state %q", sess.Sid, next)
 	}
+	sess.State = next
+	return action, nil
+}
+
+func (c Contracts) SignContracts(ctx context.Context, signer Signer, state SessionState) (*Contracts, error) {
 	for idx, element := range c.Contracts {
 		by, err := stringToBytes(element.Contract, Base64)
 		if err != nil {
 			return nil, err
 		}
 		var signedContract []byte
-		if sessionStatus == "initSignReadyEscrow" {
+		if state == SessionStateEscrow {
 			escrowContract := &escrowpb.EscrowContract{}
 
 			err = proto.Unmarshal(by, escrowContract)
 			if err != nil {
 				return nil, err
 			}
-			signedContract, err = crypto.Sign(privKey, escrowContract)
+			signedContract, err = signer.Sign(ctx, escrowContract)
 			if err != nil {
 				return nil, err
 			}
 
 		} else {
+			// Also covers SessionStateRepair: a repair job's guard contract
+			// is unmarshaled and signed the same way as an upload's.
 			guardContract := &guardpb.ContractMeta{}
 			//var guardContract proto.Message
 			err := proto.Unmarshal(by, guardContract)
 			if err != nil {
 				return nil, err
 			}
-			signedContract, err = crypto.Sign(privKey, guardContract)
+			signedContract, err = signer.Sign(ctx, guardContract)
 			if err != nil {
 				return nil, err
 			}
@@ -194,238 +757,802 @@ func (s *Shell) GetUts() string {
 	return strconv.FormatInt(time.Now().Unix(), 10)
 }
 
-func getSessionSignature(hash string, peerId string) (string, time.Time) {
-	//offline session signature
+// legacySessionSigsMu guards legacySessionSigs, which holds the
+// LegacySessionSig setting for each Shell. Shell can't gain a field for
+// this directly (it's defined outside this file), so it's tracked
+// out-of-line, keyed by instance: a single package-wide flag would let
+// one Shell's compatibility setting silently flip every other Shell's
+// requests between the legacy and signed wire formats.
+var (
+	legacySessionSigsMu sync.RWMutex
+	legacySessionSigs   = map[*Shell]bool{}
+)
+
+// LegacySessionSig toggles whether storage/upload/* requests made by s
+// send the legacy, unsigned "peerId:hash:timestamp" string instead of a
+// real signature over (peerId, hash, unixNano). Set it to true only when
+// negotiating with older btfs nodes that don't understand the new wire
+// format. It defaults to false (signed), and only affects s.
+func (s *Shell) LegacySessionSig(legacy bool) *Shell {
+	legacySessionSigsMu.Lock()
+	legacySessionSigs[s] = legacy
+	legacySessionSigsMu.Unlock()
+	return s
+}
+
+func (s *Shell) legacySessionSig() bool {
+	legacySessionSigsMu.RLock()
+	defer legacySessionSigsMu.RUnlock()
+	return legacySessionSigs[s]
+}
+
+// getSessionSignature canonicalizes (peerId, hash, unixNano) and signs it
+// with signer, so the node can verify the request actually came from the
+// holder of peerId's key and reject replays of an old signature. When
+// legacy is true it instead reproduces the old, unsigned
+// "peerId:hash:timestamp" format for nodes that don't yet verify real
+// session signatures.
+func getSessionSignature(ctx context.Context, signer Signer, hash string, peerId string, legacy bool) (string, time.Time, error) {
 	now := time.Now()
-	sessionSignature := fmt.Sprintf("%s:%s:%s", utils.PeerId, hash, "time.Now().String()")
-	return sessionSignature, now
+	if legacy {
+		return fmt.Sprintf("%s:%s:%s", peerId, hash, now.String()), now, nil
+	}
+	canonical := []byte(fmt.Sprintf("%s:%s:%d", peerId, hash, now.UnixNano()))
+	sig, err := signer.SignRaw(ctx, canonical)
+	if err != nil {
+		return "", now, err
+	}
+	return base64.StdEncoding.EncodeToString(sig), now, nil
 }
 
-// Storage upload api.
+// VerifySessionSignature verifies a signature produced by
+// getSessionSignature, reconstructing the same (peerId, hash, unixNano)
+// canonicalization from uts before checking it against pubKey. It returns
+// an error if uts isn't a valid timestamp or the signature doesn't verify,
+// so callers can reject replayed or forged session requests.
+func VerifySessionSignature(peerId string, hash string, uts string, sig string, pubKey ic.PubKey) error {
+	unixNano, err := strconv.ParseInt(uts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("session signature: invalid timestamp %q: %w", uts, err)
+	}
+	sigBytes, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return fmt.Errorf("session signature: invalid encoding: %w", err)
+	}
+	canonical := []byte(fmt.Sprintf("%s:%s:%d", peerId, hash, unixNano))
+	ok, err := pubKey.Verify(canonical, sigBytes)
+	if err != nil {
+		return fmt.Errorf("session signature: %w", err)
+	}
+	if !ok {
+		return errors.New("session signature: verification failed")
+	}
+	return nil
+}
+
+// Storage upload api. The returned session ID can be passed to
+// StorageUploadResume to drive a resumable, concurrently-signed upload, and
+// to StorageUploadProgress to check on it.
 func (s *Shell) StorageUpload(hash string, options ...StorageUploadOpts) (string, error) {
 	var out storageUploadResponse
 	rb := s.Request("storage/upload", hash)
 	for _, option := range options {
 		_ = option(rb)
 	}
-	return out.ID, rb.Exec(context.Background(), &out)
+	err := rb.Exec(context.Background(), &out)
+	if err == nil {
+		_ = s.sessionStore().Save(SessionRecord{Sid: out.ID, Hash: hash, SignedShards: map[string]bool{}})
+	}
+	return out.ID, err
 }
 
 // Storage upload api.
 func (s *Shell) StorageUploadOffSign(hash string, uts string, options ...StorageUploadOpts) (string, error) {
 	var out storageUploadResponse
-	offlinePeerSessionSignature, _ := getSessionSignature(hash, utils.PeerId)
+	signer, err := s.signer()
+	if err != nil {
+		return "", err
+	}
+	offlinePeerSessionSignature, _, err := getSessionSignature(context.Background(), signer, hash, utils.PeerId, s.legacySessionSig())
+	if err != nil {
+		return "", err
+	}
 	rb := s.Request("storage/upload/offline", hash, utils.PeerId, uts, offlinePeerSessionSignature)
 	for _, option := range options {
 		_ = option(rb)
 	}
-	return out.ID, rb.Exec(context.Background(), &out)
+	err = rb.Exec(context.Background(), &out)
+	if err == nil {
+		_ = s.sessionStore().Save(SessionRecord{Sid: out.ID, Hash: hash, Uts: uts, SignedShards: map[string]bool{}})
+	}
+	return out.ID, err
 }
 
-// Storage upload status api.
-func (s *Shell) StorageUploadStatus(id string) (Storage, error) {
-	var out Storage
-	rb := s.Request("storage/upload/status", id)
-	return out, rb.Exec(context.Background(), &out)
+// StorageUploadPause marks a resumable upload session as paused on the
+// node, so a killed or stopped client can later pick it back up with
+// StorageUploadResume instead of starting over.
+func (s *Shell) StorageUploadPause(sessionId string) error {
+	rb := s.Request("storage/upload/pause", sessionId)
+	return rb.Exec(context.Background(), nil)
 }
 
-// Storage upload get offline contract batch api.
-func (s *Shell) StorageUploadGetContractBatch(sid string, hash string, uts string, sessionStatus string) (Contracts, error) {
-	//var out Contracts
-	var out Contracts
-	offlinePeerSessionSignature, _ := getSessionSignature(hash, utils.PeerId)
-	rb := s.Request("storage/upload/getcontractbatch", sid, utils.PeerId, uts, offlinePeerSessionSignature, sessionStatus)
-	return out, rb.Exec(context.Background(), &out)
+// StorageUploadProgress reports per-shard signing progress for a resumable
+// upload session, derived from StorageUploadStatus.
+func (s *Shell) StorageUploadProgress(sessionId string) (UploadProgress, error) {
+	status, err := s.StorageUploadStatus(sessionId)
+	if err != nil {
+		return UploadProgress{}, err
+	}
+	progress := UploadProgress{
+		Sid:    sessionId,
+		Status: status.Status,
+		Total:  len(status.Shards),
+		Shards: status.Shards,
+	}
+	for _, shard := range status.Shards {
+		if shard.Status == "done" || shard.Status == "signed" {
+			progress.Signed++
+		}
+	}
+	return progress, nil
 }
 
-// Storage upload get offline unsigned data api.
-func (s *Shell) StorageUploadGetUnsignedData(sid string, hash string, uts string, sessionStatus string) (UnsignedData, error) {
-	var out UnsignedData
-	offlinePeerSessionSignature, _ := getSessionSignature(hash, utils.PeerId)
-	rb := s.Request("storage/upload/getunsigned", sid, utils.PeerId, uts, offlinePeerSessionSignature, sessionStatus)
-	return out, rb.Exec(context.Background(), &out)
-}
+// StorageUploadResume resumes a previously started resumable upload
+// session, driving it through every remaining step of the offline-signing
+// pipeline (escrow contracts, guard contracts, balance, pay channel, pay
+// request) via StorageSession.Next instead of stopping after the first
+// step. Contract-batch steps sign shards concurrently through a worker
+// pool (see Concurrency and RetryPolicy), and progress is persisted to the
+// configured SessionStore after every step so a client killed mid-resume
+// picks back up where it left off instead of re-signing committed work.
+func (s *Shell) StorageUploadResume(sessionId string, opts ...PipelineOpts) (UploadProgress, error) {
+	cfg := &pipelineConfig{concurrency: 1}
+	for _, opt := range opts {
+		_ = opt(cfg)
+	}
 
-// Storage upload sign offline contract batch api.
-func (s *Shell) StorageUploadSignBatch(sid string, hash string, unsignedBatchContracts Contracts, uts string, sessionStatus string) ([]byte, error) {
-	var out []byte
-	var signedBatchContracts *Contracts
-	var errSign error
-	offlinePeerSessionSignature, _ := getSessionSignature(hash, utils.PeerId)
-
-	if utils.PrivateKey != "" {
-		signedBatchContracts, errSign = unsignedBatchContracts.SignContracts(utils.PrivateKey, sessionStatus)
-		if errSign != nil {
-			log.Error("%s", zap.Error(errSign))
-		}
-		bytesSignBatch, err := json.Marshal(signedBatchContracts.Contracts)
-		if err != nil {
-			return nil, err
-		}
+	signer, err := s.signer()
+	if err != nil {
+		return UploadProgress{}, err
+	}
 
-		rb := s.Request("storage/upload/signcontractbatch", sid, utils.PeerId, uts, offlinePeerSessionSignature,
-			sessionStatus, string(bytesSignBatch))
-		return out, rb.Exec(context.Background(), &out)
+	record, err := s.sessionStore().Load(sessionId)
+	if err != nil {
+		record = SessionRecord{Sid: sessionId}
+	}
+	if record.SignedShards == nil {
+		record.SignedShards = map[string]bool{}
 	}
-	return nil, errors.New("private key not available in configuration file or environment variable")
-}
 
-// Storage upload sign offline data api.
-func (s *Shell) StorageUploadSign(id string, hash string, unsignedData UnsignedData, uts string, sessionStatus string) ([]byte, error) {
-	var out []byte
-	var rb *RequestBuilder
-	offlinePeerSessionSignature, _ := getSessionSignature(hash, utils.PeerId)
-	if utils.PrivateKey != "" {
-		signedBytes, err := unsignedData.SignData(utils.PrivateKey)
+	sess := NewStorageSession(record.Sid, record.Hash, record.Uts)
+	sess.State = record.State
+
+	for {
+		action, err := sess.Next(context.Background())
 		if err != nil {
-			log.Error("%s", zap.Error(err))
+			return UploadProgress{}, err
 		}
-		rb = s.Request("storage/upload/sign", id, utils.PeerId, uts, offlinePeerSessionSignature, string(signedBytes), sessionStatus)
-		return out, rb.Exec(context.Background(), &out)
-	}
-	return nil, errors.New("private key not available in configuration file or environment variable")
-}
+		if action == ActionDone {
+			break
+		}
+		record.State = sess.State
 
-const DEBUG = true
-func (s *Shell) StorageUploadSignBalance(id string, hash string, unsignedData UnsignedData, uts string, sessionStatus string) ([]byte, error) {
-	var out []byte
-	var rb *RequestBuilder
-	offlinePeerSessionSignature, _ := getSessionSignature(hash, utils.PeerId)
-	if utils.PrivateKey != "" {
-		ledgerSignedPublicKey, err := unsignedData.SignBalanceData(utils.PrivateKey)
-		if err != nil {
-			log.Error("%s", zap.Error(err))
-		}
-		signedBytes, err := proto.Marshal(ledgerSignedPublicKey)    // TODO: check if ic.Marshall is necessary!
-		if err != nil {
-			return nil, err
-		}
-		str, err := bytesToString(signedBytes, Base64)
-		if err != nil {
-			return nil, err
-		}
-		if DEBUG {
-			signedBytes, err := stringToBytes(str, Base64)
+		switch action {
+		case ActionSignEscrowContracts, ActionSignGuardContracts:
+			record.SignedShards = map[string]bool{}
+			batch, err := s.StorageUploadGetContractBatch(record.Sid, record.Hash, record.Uts, record.State)
 			if err != nil {
-				return nil, err
+				return UploadProgress{}, err
 			}
-
-			var lgSignedPubKey ledgerpb.SignedPublicKey
-			err = proto.Unmarshal(signedBytes, &lgSignedPubKey)
+			signed, err := runSigningPipeline(context.Background(), s.sessionStore(), record, batch, signer, cfg)
 			if err != nil {
-				return nil, err
+				return UploadProgress{}, err
+			}
+			// Submit the pool's signed contracts as-is: StorageUploadSignBatch
+			// would sign them a second time.
+			sessionSig, _, err := getSessionSignature(context.Background(), signer, record.Hash, utils.PeerId, s.legacySessionSig())
+			if err != nil {
+				return UploadProgress{}, err
+			}
+			bytesSignBatch, err := json.Marshal(signed.Contracts)
+			if err != nil {
+				return UploadProgress{}, err
+			}
+			var out []byte
+			rb := s.Request("storage/upload/signcontractbatch", record.Sid, utils.PeerId, record.Uts, sessionSig,
+				record.State.String(), string(bytesSignBatch))
+			if err := rb.Exec(context.Background(), &out); err != nil {
+				return UploadProgress{}, err
+			}
+		case ActionSignBalance:
+			unsigned, err := s.StorageUploadGetUnsignedData(record.Sid, record.Hash, record.Uts, record.State)
+			if err != nil {
+				return UploadProgress{}, err
 			}
+			if _, err := s.StorageUploadSignBalance(record.Sid, record.Hash, unsigned, record.Uts, record.State); err != nil {
+				return UploadProgress{}, err
+			}
+		case ActionSignPayChannel:
+			unsigned, err := s.StorageUploadGetUnsignedData(record.Sid, record.Hash, record.Uts, record.State)
+			if err != nil {
+				return UploadProgress{}, err
+			}
+			if _, err := s.StorageUploadSignPayChannel(record.Sid, record.Hash, unsigned, record.Uts, record.State, unsigned.Price); err != nil {
+				return UploadProgress{}, err
+			}
+		case ActionSignPayRequest:
+			unsigned, err := s.StorageUploadGetUnsignedData(record.Sid, record.Hash, record.Uts, record.State)
+			if err != nil {
+				return UploadProgress{}, err
+			}
+			if _, err := s.StorageUploadSignPayRequest(record.Sid, record.Hash, unsigned, record.Uts, record.State); err != nil {
+				return UploadProgress{}, err
+			}
+		}
 
-			fmt.Println(lgSignedPubKey)
+		if err := s.sessionStore().Save(record); err != nil {
+			return UploadProgress{}, err
 		}
-		rb = s.Request("storage/upload/sign", id, utils.PeerId, uts, offlinePeerSessionSignature, str, sessionStatus)
-		return out, rb.Exec(context.Background(), &out)
 	}
-	return nil, errors.New("private key not available in configuration file or environment variable")
+
+	return s.StorageUploadProgress(sessionId)
+}
+
+// Storage upload status api.
+func (s *Shell) StorageUploadStatus(id string) (Storage, error) {
+	var out Storage
+	rb := s.Request("storage/upload/status", id)
+	return out, rb.Exec(context.Background(), &out)
+}
+
+// StorageRepairResponse offers to repair shardHash of fileHash as a repair
+// host: it negotiates a repair session with the node and returns a session
+// ID, mirroring StorageUpload's state machine but for a repair job rather
+// than a new upload.
+func (s *Shell) StorageRepairResponse(fileHash, shardHash, fileSize, downloadReward, repairReward string, opts ...StorageUploadOpts) (string, error) {
+	var out storageUploadResponse
+	rb := s.Request("storage/repair/response", fileHash, shardHash, fileSize, downloadReward, repairReward)
+	for _, option := range opts {
+		_ = option(rb)
+	}
+	return out.ID, rb.Exec(context.Background(), &out)
+}
+
+// StorageRepairGetContractBatch fetches the unsigned repair guard contracts
+// for a repair session, mirroring StorageUploadGetContractBatch.
+func (s *Shell) StorageRepairGetContractBatch(sid string, hash string, uts string) (Contracts, error) {
+	var out Contracts
+	signer, err := s.signer()
+	if err != nil {
+		return out, err
+	}
+	offlinePeerSessionSignature, _, err := getSessionSignature(context.Background(), signer, hash, utils.PeerId, s.legacySessionSig())
+	if err != nil {
+		return out, err
+	}
+	rb := s.Request("storage/repair/getcontractbatch", sid, utils.PeerId, uts, offlinePeerSessionSignature, SessionStateRepair.String())
+	return out, rb.Exec(context.Background(), &out)
+}
+
+// StorageRepairSignBatch signs the repairer's guard contracts for a lost
+// shard and returns them to the node, mirroring StorageUploadSignBatch but
+// using SessionStateRepair so the repairer, not the original uploader,
+// ends up as the signer of record.
+func (s *Shell) StorageRepairSignBatch(sid string, hash string, unsignedBatchContracts Contracts, uts string) ([]byte, error) {
+	var out []byte
+	signer, err := s.signer()
+	if err != nil {
+		return nil, err
+	}
+	offlinePeerSessionSignature, _, err := getSessionSignature(context.Background(), signer, hash, utils.PeerId, s.legacySessionSig())
+	if err != nil {
+		return nil, err
+	}
+	signedBatchContracts, err := unsignedBatchContracts.SignContracts(context.Background(), signer, SessionStateRepair)
+	if err != nil {
+		log.Error("%s", zap.Error(err))
+		return nil, err
+	}
+	bytesSignBatch, err := json.Marshal(signedBatchContracts.Contracts)
+	if err != nil {
+		return nil, err
+	}
+
+	rb := s.Request("storage/repair/signcontractbatch", sid, utils.PeerId, uts, offlinePeerSessionSignature,
+		SessionStateRepair.String(), string(bytesSignBatch))
+	return out, rb.Exec(context.Background(), &out)
+}
+
+// StorageRepairStatus checks on a repair session, mirroring
+// StorageUploadStatus.
+func (s *Shell) StorageRepairStatus(id string) (Storage, error) {
+	var out Storage
+	rb := s.Request("storage/repair/status", id)
+	return out, rb.Exec(context.Background(), &out)
+}
+
+// Storage upload get offline contract batch api.
+func (s *Shell) StorageUploadGetContractBatch(sid string, hash string, uts string, state SessionState) (Contracts, error) {
+	//var out Contracts
+	var out Contracts
+	signer, err := s.signer()
+	if err != nil {
+		return out, err
+	}
+	offlinePeerSessionSignature, _, err := getSessionSignature(context.Background(), signer, hash, utils.PeerId, s.legacySessionSig())
+	if err != nil {
+		return out, err
+	}
+	rb := s.Request("storage/upload/getcontractbatch", sid, utils.PeerId, uts, offlinePeerSessionSignature, state.String())
+	return out, rb.Exec(context.Background(), &out)
+}
+
+// Storage upload get offline unsigned data api.
+func (s *Shell) StorageUploadGetUnsignedData(sid string, hash string, uts string, state SessionState) (UnsignedData, error) {
+	var out UnsignedData
+	signer, err := s.signer()
+	if err != nil {
+		return out, err
+	}
+	offlinePeerSessionSignature, _, err := getSessionSignature(context.Background(), signer, hash, utils.PeerId, s.legacySessionSig())
+	if err != nil {
+		return out, err
+	}
+	rb := s.Request("storage/upload/getunsigned", sid, utils.PeerId, uts, offlinePeerSessionSignature, state.String())
+	return out, rb.Exec(context.Background(), &out)
+}
+
+// Storage upload sign offline contract batch api.
+func (s *Shell) StorageUploadSignBatch(sid string, hash string, unsignedBatchContracts Contracts, uts string, state SessionState) ([]byte, error) {
+	var out []byte
+	signer, err := s.signer()
+	if err != nil {
+		return nil, err
+	}
+	offlinePeerSessionSignature, _, err := getSessionSignature(context.Background(), signer, hash, utils.PeerId, s.legacySessionSig())
+	if err != nil {
+		return nil, err
+	}
+	signedBatchContracts, errSign := unsignedBatchContracts.SignContracts(context.Background(), signer, state)
+	if errSign != nil {
+		log.Error("%s", zap.Error(errSign))
+		return nil, errSign
+	}
+	bytesSignBatch, err := json.Marshal(signedBatchContracts.Contracts)
+	if err != nil {
+		return nil, err
+	}
+
+	rb := s.Request("storage/upload/signcontractbatch", sid, utils.PeerId, uts, offlinePeerSessionSignature,
+		state.String(), string(bytesSignBatch))
+	return out, rb.Exec(context.Background(), &out)
 }
 
-func (s *Shell) StorageUploadSignPayChannel(id, hash string, unsignedData UnsignedData, uts string, sessionStatus string, totalPrice int64) ([]byte, error) {
+// Storage upload sign offline data api.
+func (s *Shell) StorageUploadSign(id string, hash string, unsignedData UnsignedData, uts string, state SessionState) ([]byte, error) {
 	var out []byte
 	var rb *RequestBuilder
-	offlinePeerSessionSignature, now := getSessionSignature(hash, utils.PeerId)
-	if utils.PrivateKey != "" {
-		unsignedBytes, err := stringToBytes(unsignedData.Unsigned, Base64)
-		if err != nil {
-			return nil, err
-		}
-		escrowPubKey, err := ic.UnmarshalPublicKey(unsignedBytes)
-		if err != nil {
-			return nil, err
-		}
-		buyerPubKey, err := crypto.ToPubKey(utils.PublicKey)
-		if err != nil {
-			return nil, err
-		}
-		fromAddr, err := ic.RawFull(buyerPubKey)
-		if err != nil {
-			return nil, err
-		}
-		toAddr, err := ic.RawFull(escrowPubKey)
-		if err != nil {
-			return nil, err
-		}
-		chanCommit := &ledgerpb.ChannelCommit{
-			Payer:     &ledgerpb.PublicKey{Key: fromAddr},
-			Recipient: &ledgerpb.PublicKey{Key: toAddr},
-			Amount: totalPrice,
-			PayerId: now.UnixNano(),
-		}
-		buyerPrivKey, err := crypto.ToPrivKey(utils.PrivateKey)
-		if err != nil {
-			return nil, err
-		}
-		buyerChanSig, err := crypto.Sign(buyerPrivKey, chanCommit)
-		if err != nil {
-			return nil, err
-		}
-		signedChanCommit := &ledgerpb.SignedChannelCommit{
-			Channel:   chanCommit,
-			Signature: buyerChanSig,
-		}
-		signedChanCommitBytes, err := proto.Marshal(signedChanCommit)
+	signer, err := s.signer()
+	if err != nil {
+		return nil, err
+	}
+	offlinePeerSessionSignature, _, err := getSessionSignature(context.Background(), signer, hash, utils.PeerId, s.legacySessionSig())
+	if err != nil {
+		return nil, err
+	}
+	signedBytes, err := unsignedData.SignData(context.Background(), signer)
+	if err != nil {
+		log.Error("%s", zap.Error(err))
+	}
+	rb = s.Request("storage/upload/sign", id, utils.PeerId, uts, offlinePeerSessionSignature, string(signedBytes), state.String())
+	return out, rb.Exec(context.Background(), &out)
+}
+
+const DEBUG = true
+
+func (s *Shell) StorageUploadSignBalance(id string, hash string, unsignedData UnsignedData, uts string, state SessionState) ([]byte, error) {
+	var out []byte
+	var rb *RequestBuilder
+	signer, err := s.signer()
+	if err != nil {
+		return nil, err
+	}
+	offlinePeerSessionSignature, _, err := getSessionSignature(context.Background(), signer, hash, utils.PeerId, s.legacySessionSig())
+	if err != nil {
+		return nil, err
+	}
+	ledgerSignedPublicKey, err := unsignedData.SignBalanceData(context.Background(), signer)
+	if err != nil {
+		log.Error("%s", zap.Error(err))
+	}
+	signedBytes, err := proto.Marshal(ledgerSignedPublicKey) // TODO: check if ic.Marshall is necessary!
+	if err != nil {
+		return nil, err
+	}
+	str, err := bytesToString(signedBytes, Base64)
+	if err != nil {
+		return nil, err
+	}
+	if DEBUG {
+		signedBytes, err := stringToBytes(str, Base64)
 		if err != nil {
 			return nil, err
 		}
-		signedChanCommitBytesStr, err := bytesToString(signedChanCommitBytes, Base64)
+
+		var lgSignedPubKey ledgerpb.SignedPublicKey
+		err = proto.Unmarshal(signedBytes, &lgSignedPubKey)
 		if err != nil {
 			return nil, err
 		}
-		rb = s.Request("storage/upload/sign", id, utils.PeerId, uts, offlinePeerSessionSignature, signedChanCommitBytesStr, sessionStatus)
-		return out, rb.Exec(context.Background(), &out)
+
+		fmt.Println(lgSignedPubKey)
+	}
+	rb = s.Request("storage/upload/sign", id, utils.PeerId, uts, offlinePeerSessionSignature, str, state.String())
+	return out, rb.Exec(context.Background(), &out)
+}
+
+func (s *Shell) StorageUploadSignPayChannel(id, hash string, unsignedData UnsignedData, uts string, state SessionState, totalPrice int64) ([]byte, error) {
+	var out []byte
+	var rb *RequestBuilder
+	signer, err := s.signer()
+	if err != nil {
+		return nil, err
+	}
+	offlinePeerSessionSignature, now, err := getSessionSignature(context.Background(), signer, hash, utils.PeerId, s.legacySessionSig())
+	if err != nil {
+		return nil, err
+	}
+	unsignedBytes, err := stringToBytes(unsignedData.Unsigned, Base64)
+	if err != nil {
+		return nil, err
+	}
+	escrowPubKey, err := ic.UnmarshalPublicKey(unsignedBytes)
+	if err != nil {
+		return nil, err
+	}
+	fromAddr, err := ic.RawFull(signer.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	toAddr, err := ic.RawFull(escrowPubKey)
+	if err != nil {
+		return nil, err
+	}
+	chanCommit := &ledgerpb.ChannelCommit{
+		Payer:     &ledgerpb.PublicKey{Key: fromAddr},
+		Recipient: &ledgerpb.PublicKey{Key: toAddr},
+		Amount:    totalPrice,
+		PayerId:   now.UnixNano(),
+	}
+	buyerChanSig, err := signer.Sign(context.Background(), chanCommit)
+	if err != nil {
+		return nil, err
 	}
-	return nil, errors.New("private key not available in configuration file or environment variable")
+	signedChanCommit := &ledgerpb.SignedChannelCommit{
+		Channel:   chanCommit,
+		Signature: buyerChanSig,
+	}
+	signedChanCommitBytes, err := proto.Marshal(signedChanCommit)
+	if err != nil {
+		return nil, err
+	}
+	signedChanCommitBytesStr, err := bytesToString(signedChanCommitBytes, Base64)
+	if err != nil {
+		return nil, err
+	}
+	rb = s.Request("storage/upload/sign", id, utils.PeerId, uts, offlinePeerSessionSignature, signedChanCommitBytesStr, state.String())
+	return out, rb.Exec(context.Background(), &out)
 }
 
 func (s *Shell) StorageUploadSignPayRequest(id, hash string, unsignedData UnsignedData,
-	uts string, sessionStatus string) ([]byte, error) {
+	uts string, state SessionState) ([]byte, error) {
 	var out []byte
 	var rb *RequestBuilder
-	offlinePeerSessionSignature, _ := getSessionSignature(hash, utils.PeerId)
-	if utils.PrivateKey != "" {
-		result := new(escrowpb.SignedSubmitContractResult)
-		err := proto.Unmarshal([]byte(unsignedData.Unsigned), result)
-		if err != nil {
-			return nil, err
+	signer, err := s.signer()
+	if err != nil {
+		return nil, err
+	}
+	offlinePeerSessionSignature, _, err := getSessionSignature(context.Background(), signer, hash, utils.PeerId, s.legacySessionSig())
+	if err != nil {
+		return nil, err
+	}
+	result := new(escrowpb.SignedSubmitContractResult)
+	err = proto.Unmarshal([]byte(unsignedData.Unsigned), result)
+	if err != nil {
+		return nil, err
+	}
+
+	chanState := result.Result.BuyerChannelState
+	sig, err := signer.Sign(context.Background(), chanState)
+	if err != nil {
+		return nil, err
+	}
+	chanState.FromSignature = sig
+	payerAddr, err := signer.PublicKey().Raw()
+	if err != nil {
+		return nil, err
+	}
+	payinReq := &escrowpb.PayinRequest{
+		PayinId:           result.Result.PayinId,
+		BuyerAddress:      payerAddr,
+		BuyerChannelState: chanState,
+	}
+	payinSig, err := signer.Sign(context.Background(), payinReq)
+	if err != nil {
+		return nil, err
+	}
+	signedPayinReq := &escrowpb.SignedPayinRequest{
+		Request:        payinReq,
+		BuyerSignature: payinSig,
+	}
+
+	signedPayinReqBytes, err := proto.Marshal(signedPayinReq)
+	if err != nil {
+		return nil, err
+	}
+
+	rb = s.Request("storage/upload/sign", id, utils.PeerId, uts, offlinePeerSessionSignature, string(signedPayinReqBytes), state.String())
+	return out, rb.Exec(context.Background(), &out)
+}
+
+// StorageAsk is a host's published price to store a piece, mirroring the
+// ask/bid records used by storage markets like Filecoin's. Signature
+// covers the rest of the fields (see SignStorageAsk/Verify) so a client
+// can confirm the host that published Price actually signed it, rather
+// than trusting whatever the node forwards on its behalf.
+type StorageAsk struct {
+	Host         string
+	Price        int64
+	MinPieceSize int64
+	Timestamp    time.Time
+	Expiry       time.Time
+	SeqNo        int64
+	Signature    string
+}
+
+func (a StorageAsk) canonical() []byte {
+	return []byte(fmt.Sprintf("%s:%d:%d:%d:%d:%d", a.Host, a.Price, a.MinPieceSize, a.Timestamp.UnixNano(), a.Expiry.UnixNano(), a.SeqNo))
+}
+
+// SignStorageAsk signs ask's canonical fields with signer, so a host
+// publishing it can prove authorship of the advertised price. This uses
+// the same Signer abstraction SignContracts/SignData sign through.
+func SignStorageAsk(ctx context.Context, signer Signer, ask StorageAsk) (StorageAsk, error) {
+	sig, err := signer.SignRaw(ctx, ask.canonical())
+	if err != nil {
+		return StorageAsk{}, err
+	}
+	ask.Signature = base64.StdEncoding.EncodeToString(sig)
+	return ask, nil
+}
+
+// Verify checks ask's Signature against pubKey, returning an error if it
+// doesn't verify.
+func (a StorageAsk) Verify(pubKey ic.PubKey) error {
+	sig, err := base64.StdEncoding.DecodeString(a.Signature)
+	if err != nil {
+		return fmt.Errorf("storage ask: invalid signature encoding: %w", err)
+	}
+	ok, err := pubKey.Verify(a.canonical(), sig)
+	if err != nil {
+		return fmt.Errorf("storage ask: %w", err)
+	}
+	if !ok {
+		return errors.New("storage ask: signature verification failed")
+	}
+	return nil
+}
+
+// StorageAskFilter narrows the results of ListStorageAsks, e.g. by
+// maximum price or minimum piece size.
+type StorageAskFilter = func(StorageAsk) bool
+
+// MaxAskPrice returns a StorageAskFilter that keeps only asks at or below
+// price.
+func MaxAskPrice(price int64) StorageAskFilter {
+	return func(ask StorageAsk) bool {
+		return ask.Price <= price
+	}
+}
+
+// MinAskPieceSize returns a StorageAskFilter that keeps only asks willing
+// to store pieces at least size bytes.
+func MinAskPieceSize(size int64) StorageAskFilter {
+	return func(ask StorageAsk) bool {
+		return ask.MinPieceSize <= size
+	}
+}
+
+type storageAskListResponse struct {
+	Asks []StorageAsk
+}
+
+type storageAskResponse struct {
+	Ask StorageAsk
+}
+
+// askCacheTTL bounds how long ListStorageAsks trusts its cached listing
+// before re-polling the node, independent of any individual ask's own
+// Expiry, so a newly published ask or an updated price shows up promptly
+// instead of waiting for every cached entry to expire at once.
+const askCacheTTL = 30 * time.Second
+
+// askCache caches StorageAsks locally, keyed by host, evicting an entry
+// once it passes its own Expiry so ListStorageAsks/GetStorageAsk never
+// hand back a price the host has withdrawn.
+type askCache struct {
+	mu        sync.Mutex
+	asks      map[string]StorageAsk
+	lastFetch time.Time
+}
+
+func newAskCache() *askCache {
+	return &askCache{asks: map[string]StorageAsk{}}
+}
+
+func (c *askCache) get(host string) (StorageAsk, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ask, ok := c.asks[host]
+	if !ok {
+		return StorageAsk{}, false
+	}
+	if time.Now().After(ask.Expiry) {
+		delete(c.asks, host)
+		return StorageAsk{}, false
+	}
+	return ask, true
+}
+
+func (c *askCache) put(ask StorageAsk) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.asks[ask.Host] = ask
+}
+
+// putAll records a fresh full listing fetched from the node, resetting
+// the staleness clock that stale() checks.
+func (c *askCache) putAll(asks []StorageAsk) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ask := range asks {
+		c.asks[ask.Host] = ask
+	}
+	c.lastFetch = time.Now()
+}
+
+// stale reports whether the cache hasn't been refreshed from the node
+// within askCacheTTL, regardless of whether any cached ask has itself
+// expired yet.
+func (c *askCache) stale() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Since(c.lastFetch) > askCacheTTL
+}
+
+func (c *askCache) list() []StorageAsk {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	out := make([]StorageAsk, 0, len(c.asks))
+	for host, ask := range c.asks {
+		if now.After(ask.Expiry) {
+			delete(c.asks, host)
+			continue
 		}
+		out = append(out, ask)
+	}
+	return out
+}
 
-		chanState := result.Result.BuyerChannelState
-		privKey, _ := crypto.ToPrivKey(utils.PrivateKey)
-		sig, err := crypto.Sign(privKey, chanState)
-		if err != nil {
+// askCachesMu guards askCaches, which holds the ask cache for each Shell.
+// Shell can't gain a field for this directly (it's defined outside this
+// file), so it's tracked out-of-line, keyed by instance, instead of as a
+// single cache that every Shell would otherwise share.
+var (
+	askCachesMu sync.Mutex
+	askCaches   = map[*Shell]*askCache{}
+)
+
+func (s *Shell) askCache() *askCache {
+	askCachesMu.Lock()
+	defer askCachesMu.Unlock()
+	cache, ok := askCaches[s]
+	if !ok {
+		cache = newAskCache()
+		askCaches[s] = cache
+	}
+	return cache
+}
+
+// ListStorageAsks lists the storage asks currently published by hosts,
+// optionally narrowed by filters such as MaxAskPrice or MinAskPieceSize.
+// Asks are served from a local cache keyed on host; the node is re-polled
+// whenever the cache is older than askCacheTTL, not merely when it's
+// empty, so a new host's ask or an updated price is picked up promptly.
+func (s *Shell) ListStorageAsks(ctx context.Context, filters ...StorageAskFilter) ([]StorageAsk, error) {
+	cache := s.askCache()
+	if cache.stale() {
+		var out storageAskListResponse
+		rb := s.Request("storage/ask/list")
+		if err := rb.Exec(ctx, &out); err != nil {
 			return nil, err
 		}
-		chanState.FromSignature = sig
-		payerPubKey, _ := crypto.ToPrivKey(utils.PublicKey)
-		payerAddr, err := payerPubKey.Raw()
-		if err != nil {
-			return nil, err
+		cache.putAll(out.Asks)
+	}
+	cached := cache.list()
+	result := make([]StorageAsk, 0, len(cached))
+	for _, ask := range cached {
+		keep := true
+		for _, filter := range filters {
+			if !filter(ask) {
+				keep = false
+				break
+			}
 		}
-		payinReq := &escrowpb.PayinRequest{
-			PayinId:           result.Result.PayinId,
-			BuyerAddress:      payerAddr,
-			BuyerChannelState: chanState,
+		if keep {
+			result = append(result, ask)
 		}
-		payinSig, err := crypto.Sign(privKey, payinReq)
+	}
+	return result, nil
+}
+
+// GetStorageAsk fetches the storage ask currently published by hostId,
+// serving it from the local cache when it hasn't expired yet.
+func (s *Shell) GetStorageAsk(hostId string) (StorageAsk, error) {
+	cache := s.askCache()
+	if ask, ok := cache.get(hostId); ok {
+		return ask, nil
+	}
+	var out storageAskResponse
+	rb := s.Request("storage/ask/get", hostId)
+	if err := rb.Exec(context.Background(), &out); err != nil {
+		return StorageAsk{}, err
+	}
+	cache.put(out.Ask)
+	return out.Ask, nil
+}
+
+// HostSelectorFunc scores a candidate StorageAsk for suitability; higher
+// scores are preferred. Callers can weigh price, latency, or reputation
+// however suits them instead of being limited to whatever a single
+// built-in heuristic considers "best".
+type HostSelectorFunc func(StorageAsk) float64
+
+// PriceHostSelector is a HostSelectorFunc that prefers the cheapest asks
+// with a positive Price. An ask with a zero or negative Price scores 0,
+// so it ranks below every positive-priced ask.
+func PriceHostSelector(ask StorageAsk) float64 {
+	if ask.Price <= 0 {
+		return 0
+	}
+	return 1 / float64(ask.Price)
+}
+
+// HostSelector returns a StorageUploadOpts that replaces the opaque
+// Hosts(hosts string) comma-list with up to count hosts chosen from s's
+// published storage asks (see ListStorageAsks), ranked by score instead
+// of being hand-picked by the caller.
+func (s *Shell) HostSelector(score HostSelectorFunc, count int, filters ...StorageAskFilter) StorageUploadOpts {
+	return func(rb *RequestBuilder) error {
+		asks, err := s.ListStorageAsks(context.Background(), filters...)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		signedPayinReq := &escrowpb.SignedPayinRequest{
-			Request:        payinReq,
-			BuyerSignature: payinSig,
+		if count <= 0 {
+			return fmt.Errorf("host selector: count must be positive, got %d", count)
 		}
-
-		signedPayinReqBytes, err := proto.Marshal(signedPayinReq)
-		if err != nil {
-			return nil, err
+		sort.Slice(asks, func(i, j int) bool {
+			return score(asks[i]) > score(asks[j])
+		})
+		if count < len(asks) {
+			asks = asks[:count]
 		}
-
-		rb = s.Request("storage/upload/sign", id, utils.PeerId, uts, offlinePeerSessionSignature, string(signedPayinReqBytes), sessionStatus)
-		return out, rb.Exec(context.Background(), &out)
+		hosts := make([]string, len(asks))
+		for i, ask := range asks {
+			hosts[i] = ask.Host
+		}
+		rb.Option("s", strings.Join(hosts, ","))
+		return nil
 	}
-	return nil, errors.New("private key not available in configuration file or environment variable")
 }